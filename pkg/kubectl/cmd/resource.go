@@ -17,24 +17,45 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/meta"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/validation"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubectl"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubectl/plugins"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util/strategicpatch"
+	"github.com/evanphx/json-patch"
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
 )
 
+// lastAppliedConfigAnnotation records the configuration most recently passed
+// to Apply, so later calls can compute their patch from it (last-applied to
+// modified) instead of blindly overwriting fields a controller manages.
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+const (
+	strategicMergePatchType = "application/strategic-merge-patch+json"
+	mergePatchType          = "application/merge-patch+json"
+)
+
 // Resource defines interface for resources
 type Resource interface {
 	Delete(io.Writer) error
 	Get(io.Writer) (runtime.Object, error)
+	Apply(io.Writer) error
 }
 
 // ResourceInfo contains temporary info to execute REST call
@@ -43,6 +64,9 @@ type ResourceInfo struct {
 	Mapping   *meta.RESTMapping
 	Namespace string
 	Name      string
+	// Data holds the raw object definition to use on Apply. It is empty for
+	// resources that were only ever looked up by name (e.g. for get/delete).
+	Data []byte
 }
 
 func NewResourceInfo(client kubectl.RESTClient, mapping *meta.RESTMapping, namespace, name string) *ResourceInfo {
@@ -54,6 +78,19 @@ func NewResourceInfo(client kubectl.RESTClient, mapping *meta.RESTMapping, names
 	}
 }
 
+// SetData attaches the raw object definition Apply should create or update
+// the resource with.
+func (r *ResourceInfo) SetData(data []byte) *ResourceInfo {
+	r.Data = data
+	return r
+}
+
+// Kind returns the REST mapping's kind, used by OrderedResourceSet to
+// determine apply/delete priority.
+func (r *ResourceInfo) Kind() string {
+	return r.Mapping.Kind
+}
+
 func (r *ResourceInfo) Delete(out io.Writer) error {
 	err := kubectl.NewRESTHelper(r.Client, r.Mapping).Delete(r.Namespace, r.Name)
 	if err == nil {
@@ -67,6 +104,104 @@ func (r *ResourceInfo) Get(out io.Writer) (runtime.Object, error) {
 	return kubectl.NewRESTHelper(r.Client, r.Mapping).Get(r.Namespace, r.Name, labelSelector)
 }
 
+// Apply reconciles the live object with the definition previously attached
+// with SetData. If the object cannot be fetched it is created outright,
+// stamped with lastAppliedConfigAnnotation. Otherwise a patch is computed
+// between the last-applied config recorded on the live object and the newly
+// supplied config, and sent to the live object: a strategic-merge diff (using
+// the object's patchStrategy/patchMergeKey struct tags) so list fields merge
+// by key instead of by index, falling back to a plain JSON merge patch for
+// kinds with no strategic-merge metadata. The live object's own field values
+// are not part of the diff: a field whose live value differs from the
+// last-applied config but which the new config leaves unchanged is not reset.
+func (r *ResourceInfo) Apply(out io.Writer) error {
+	if len(r.Data) == 0 {
+		return fmt.Errorf("no data to apply for resource %s", r.Name)
+	}
+	helper := kubectl.NewRESTHelper(r.Client, r.Mapping)
+	var labelSelector labels.Selector = nil
+	live, err := helper.Get(r.Namespace, r.Name, labelSelector)
+	if err != nil {
+		return r.applyCreate(out, helper)
+	}
+	return r.applyPatch(out, helper, live)
+}
+
+// withLastApplied returns obj with lastAppliedConfigAnnotation set to
+// config, so the next Apply has something to three-way merge against.
+func (r *ResourceInfo) withLastApplied(obj runtime.Object, config []byte) (runtime.Object, error) {
+	accessor := r.Mapping.MetadataAccessor
+	annotations, err := accessor.Annotations(obj)
+	if err != nil || annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[lastAppliedConfigAnnotation] = string(config)
+	if err := accessor.SetAnnotations(obj, annotations); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (r *ResourceInfo) applyCreate(out io.Writer, helper *kubectl.RESTHelper) error {
+	obj, err := r.Mapping.Codec.Decode(r.Data)
+	if err != nil {
+		return err
+	}
+	obj, err = r.withLastApplied(obj, r.Data)
+	if err != nil {
+		return err
+	}
+	if err := helper.Create(r.Namespace, obj); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s created\n", r.Name)
+	return nil
+}
+
+func (r *ResourceInfo) applyPatch(out io.Writer, helper *kubectl.RESTHelper, live runtime.Object) error {
+	original := []byte("{}")
+	if annotations, err := r.Mapping.MetadataAccessor.Annotations(live); err == nil {
+		if last, ok := annotations[lastAppliedConfigAnnotation]; ok && len(last) > 0 {
+			original = []byte(last)
+		}
+	}
+
+	modifiedObj, err := r.Mapping.Codec.Decode(r.Data)
+	if err != nil {
+		return err
+	}
+	modifiedObj, err = r.withLastApplied(modifiedObj, r.Data)
+	if err != nil {
+		return err
+	}
+	modifiedData, err := r.Mapping.Codec.Encode(modifiedObj)
+	if err != nil {
+		return err
+	}
+
+	patchType := strategicMergePatchType
+	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(original, modifiedData, modifiedObj)
+	if err == strategicpatch.ErrNoStructMetadata {
+		// The type has no patchStrategy/patchMergeKey metadata (e.g. a CRD
+		// with no registered Go struct); fall back to a generic JSON merge
+		// patch against the last-applied config, not the live object - a
+		// merge patch nulls out every key its base has that its target
+		// lacks, and the live object has plenty the user's file never
+		// mentions (status, resourceVersion, controller-defaulted fields).
+		patchType = mergePatchType
+		patchBytes, err = jsonpatch.CreateMergePatch(original, modifiedData)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := helper.Patch(r.Namespace, r.Name, patchType, patchBytes); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s configured\n", r.Name)
+	return nil
+}
+
 // ResourceSelector is a facade for all the resources fetched via label selector
 type ResourceSelector struct {
 	Client    kubectl.RESTClient
@@ -106,49 +241,414 @@ func (r *ResourceSelector) Get(out io.Writer) (runtime.Object, error) {
 	return kubectl.NewRESTHelper(r.Client, r.Mapping).List(r.Namespace, r.Selector)
 }
 
+// Apply is not supported for a selector-backed set of resources: there is no
+// single object definition to reconcile a whole label selection against.
+func (r *ResourceSelector) Apply(out io.Writer) error {
+	return fmt.Errorf("cannot apply to resources selected by label, apply a specific name instead")
+}
+
+// Kind returns the REST mapping's kind, used by OrderedResourceSet to
+// determine apply/delete priority.
+func (r *ResourceSelector) Kind() string {
+	return r.Mapping.Kind
+}
+
+// NamedRESTClient pairs a REST client with the kubeconfig context it was
+// built for, so multi-cluster output can be attributed to the cluster it
+// came from.
+type NamedRESTClient struct {
+	Context string
+	Client  kubectl.RESTClient
+}
+
 // ResourcesFromArgsOrFile: compute a list of of Resources
 // extracting info from filename or  args
-func ResourcesFromArgsOrFile(cmd *cobra.Command, args []string, filename, selector string, typer runtime.ObjectTyper, mapper meta.RESTMapper, clientBuilder func(cmd *cobra.Command, mapping *meta.RESTMapping) (kubectl.RESTClient, error), schema validation.Schema) (resources []Resource) {
+//
+// clientsBuilder returns one client per target context (ordinarily just the
+// current context, or one per entry in --clusters/--all-contexts). When it
+// returns more than one client, the resulting Resource fans the operation
+// out across all of them via MultiClusterResource.
+func ResourcesFromArgsOrFile(cmd *cobra.Command, args []string, filename, selector string, typer runtime.ObjectTyper, mapper meta.RESTMapper, clientsBuilder func(cmd *cobra.Command, mapping *meta.RESTMapping) ([]NamedRESTClient, error), schema validation.Schema) (resources []Resource) {
+	defer func() { resources = OrderResources(resources) }()
 
 	if len(selector) == 0 { // handling filename & resource id
-		mapping, namespace, name := ResourceFromArgsOrFile(cmd, args, filename, typer, mapper, schema)
-		client, err := clientBuilder(cmd, mapping)
-		checkErr(err)
-		resources = append(resources, NewResourceInfo(client, mapping, namespace, name))
+		if len(args) > 0 && len(args) != 2 {
+			usageError(cmd, "If passing in command line parameters, must be resource and name")
+		}
+		if len(args) == 2 {
+			mapping, namespace, name, data, plugin := ResourceFromArgsOrFile(cmd, args, filename, typer, mapper, schema)
+			if plugin != nil {
+				resources = append(resources, plugin)
+				return
+			}
+			clients, err := clientsBuilder(cmd, mapping)
+			checkErr(err)
+			checkErr(compareNamespaceForContexts(cmd, namespace, clients))
+			if len(clients) == 1 {
+				resources = append(resources, NewResourceInfo(clients[0].Client, mapping, namespace, name).SetData(data))
+				return
+			}
+			resources = append(resources, newMultiClusterResourceInfo(clients, mapping, namespace, name, data))
+			return
+		}
+		if len(filename) == 0 {
+			usageError(cmd, "Must specify filename or command line params")
+		}
+		for _, doc := range ResourcesFromFile(filename, typer, mapper, schema) {
+			if doc.Plugin != nil {
+				resources = append(resources, doc.Plugin)
+				continue
+			}
+			if len(doc.Name) == 0 {
+				checkErr(fmt.Errorf("the resource in the provided file has no name (or ID) defined"))
+			}
+			clients, err := clientsBuilder(cmd, doc.Mapping)
+			checkErr(err)
+			checkErr(compareNamespaceForContexts(cmd, doc.Namespace, clients))
+			if len(clients) == 1 {
+				resources = append(resources, NewResourceInfo(clients[0].Client, doc.Mapping, doc.Namespace, doc.Name).SetData(doc.Data))
+				continue
+			}
+			resources = append(resources, newMultiClusterResourceInfo(clients, doc.Mapping, doc.Namespace, doc.Name, doc.Data))
+		}
 		return
 	}
 	labelSelector, err := labels.ParseSelector(selector)
 	checkErr(err)
 	for _, a := range args {
 		for _, arg := range SplitResourceArgument(a, mapper) {
-			resource := kubectl.ExpandResourceShortcut(arg)
+			resource := expandResourceShortcut(arg)
 			if len(resource) == 0 {
 				usageError(cmd, "Unknown resource %s", resource)
 			}
+			namespace := GetKubeNamespace(cmd)
 			version, kind, err := mapper.VersionAndKindForResource(resource)
-			checkErr(err)
+			if err != nil {
+				if p, ok := plugins.Lookup(resource); ok {
+					resources = append(resources, newPluginResource(p, namespace, labelSelector))
+					continue
+				}
+				checkErr(err)
+			}
 			mapping, err := mapper.RESTMapping(version, kind)
 			checkErr(err)
-			client, err := clientBuilder(cmd, mapping)
+			clients, err := clientsBuilder(cmd, mapping)
 			checkErr(err)
-			namespace := GetKubeNamespace(cmd)
-			resources = append(resources, NewResourceSelector(client, mapping, namespace, labelSelector))
+			if len(clients) == 1 {
+				resources = append(resources, NewResourceSelector(clients[0].Client, mapping, namespace, labelSelector))
+				continue
+			}
+			selectors := make([]kindedResource, 0, len(clients))
+			for _, c := range clients {
+				selectors = append(selectors, NewResourceSelector(c.Client, mapping, namespace, labelSelector))
+			}
+			resources = append(resources, newMultiClusterResource(clients, selectors))
+		}
+	}
+	return
+}
+
+// ResourcesFromDir builds one Resource per document in every manifest file
+// directly inside dir (non-recursive) - a file holding a multi-document
+// YAML/JSON manifest contributes one Resource per document - each carrying
+// the data Apply needs to reconcile it. This is what an `apply -f dir/`
+// subcommand iterates over.
+func ResourcesFromDir(cmd *cobra.Command, dir string, typer runtime.ObjectTyper, mapper meta.RESTMapper, clientsBuilder func(cmd *cobra.Command, mapping *meta.RESTMapping) ([]NamedRESTClient, error), schema validation.Schema) (resources []Resource) {
+	defer func() { resources = OrderResources(resources) }()
+	entries, err := ioutil.ReadDir(dir)
+	checkErr(err)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		for _, doc := range ResourcesFromFile(path, typer, mapper, schema) {
+			if doc.Plugin != nil {
+				resources = append(resources, doc.Plugin)
+				continue
+			}
+			if len(doc.Name) == 0 {
+				checkErr(fmt.Errorf("a resource in %s has no name (or ID) defined", path))
+			}
+			clients, err := clientsBuilder(cmd, doc.Mapping)
+			checkErr(err)
+			checkErr(compareNamespaceForContexts(cmd, doc.Namespace, clients))
+			if len(clients) == 1 {
+				resources = append(resources, NewResourceInfo(clients[0].Client, doc.Mapping, doc.Namespace, doc.Name).SetData(doc.Data))
+				continue
+			}
+			resources = append(resources, newMultiClusterResourceInfo(clients, doc.Mapping, doc.Namespace, doc.Name, doc.Data))
 		}
 	}
 	return
 }
 
+// expandResourceShortcut resolves a short name to its full resource name,
+// consulting the plugin registry before falling back to kubectl's built-in
+// table, so a plugin can introduce new short names (or shadow an existing
+// one) without touching kubectl.ExpandResourceShortcut itself.
+func expandResourceShortcut(name string) string {
+	if kind := plugins.ExpandShortcut(name); len(kind) > 0 {
+		return kind
+	}
+	return kubectl.ExpandResourceShortcut(name)
+}
+
+// PluginResource adapts a plugins.ResourcePlugin to the Resource interface,
+// for kinds the core RESTMapper does not know about at all (CRDs and other
+// kinds registered only via the plugin registry).
+type PluginResource struct {
+	Plugin    plugins.ResourcePlugin
+	Namespace string
+	Name      string
+	Selector  labels.Selector
+	Data      []byte
+}
+
+func newPluginResource(p plugins.ResourcePlugin, namespace string, selector labels.Selector) *PluginResource {
+	return &PluginResource{Plugin: p, Namespace: namespace, Selector: selector}
+}
+
+// SetData attaches the raw object definition Apply should create the
+// resource with.
+func (r *PluginResource) SetData(data []byte) *PluginResource {
+	r.Data = data
+	return r
+}
+
+func (r *PluginResource) Kind() string { return r.Plugin.Kind() }
+
+func (r *PluginResource) Delete(out io.Writer) error {
+	if r.Selector != nil {
+		return fmt.Errorf("deleting plugin-backed resources by label selector is not supported, delete by name instead")
+	}
+	if err := r.Plugin.Delete(r.Namespace, r.Name); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s\n", r.Name)
+	return nil
+}
+
+func (r *PluginResource) Get(out io.Writer) (runtime.Object, error) {
+	if r.Selector != nil {
+		return r.Plugin.List(r.Namespace, r.Selector)
+	}
+	return r.Plugin.Get(r.Namespace, r.Name)
+}
+
+// Apply creates r if it does not exist yet. If it does, Apply requires the
+// plugin to implement plugins.ResourceUpdater and calls Update through it;
+// plugins that don't implement ResourceUpdater only support create-on-first-
+// apply, so re-running apply against an existing plugin-backed resource
+// reports that limitation instead of either erroring out of Plugin.Create or
+// silently creating a duplicate.
+func (r *PluginResource) Apply(out io.Writer) error {
+	if len(r.Data) == 0 {
+		return fmt.Errorf("no data to apply for resource %s", r.Name)
+	}
+	if _, err := r.Plugin.Get(r.Namespace, r.Name); err == nil {
+		updater, ok := r.Plugin.(plugins.ResourceUpdater)
+		if !ok {
+			return fmt.Errorf("%s already exists and its plugin (%s) does not support updates; implement plugins.ResourceUpdater to allow re-applying", r.Name, r.Plugin.Kind())
+		}
+		if _, err := updater.Update(r.Data, r.Namespace, r.Name); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s configured\n", r.Name)
+		return nil
+	}
+	if _, err := r.Plugin.Create(r.Data, r.Namespace); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s created\n", r.Name)
+	return nil
+}
+
+// compareNamespaceForContexts checks the resource's namespace against the
+// CLI/file namespace before a multi-cluster batch runs. CompareNamespaceFromFile
+// only compares the invocation itself (the --namespace flag against the
+// resource's file, if any) and takes no per-cluster input, so it gives the
+// same answer for every client in clients; a single call is therefore
+// sufficient; looping over clients would just repeat the same check.
+func compareNamespaceForContexts(cmd *cobra.Command, namespace string, clients []NamedRESTClient) error {
+	return CompareNamespaceFromFile(cmd, namespace)
+}
+
+// AddPluginDirFlag registers the --plugin-dir flag used to point kubectl at
+// a directory of resource plugins to load at startup.
+func AddPluginDirFlag(cmd *cobra.Command) {
+	cmd.Flags().String("plugin-dir", "", "If set, load resource plugins (*.so) from this directory at startup")
+}
+
+// LoadPlugins loads the directory named by --plugin-dir, if any, into the
+// resource plugin registry. Callers should invoke this once, before parsing
+// any resources, so plugin-registered kinds are resolvable for the rest of
+// the command's run.
+func LoadPlugins(cmd *cobra.Command) error {
+	dir, _ := cmd.Flags().GetString("plugin-dir")
+	if len(dir) == 0 {
+		return nil
+	}
+	return plugins.LoadDir(dir)
+}
+
+// AddClusterFlags registers the --clusters and --all-contexts flags used to
+// target more than one cluster from a single invocation.
+func AddClusterFlags(cmd *cobra.Command) {
+	cmd.Flags().String("clusters", "", "Comma-separated list of kubeconfig contexts to target; defaults to the current context")
+	cmd.Flags().Bool("all-contexts", false, "Target every context defined in kubeconfig")
+}
+
+// ContextsFromFlags resolves the set of kubeconfig contexts a command should
+// target: --all-contexts wins over an explicit --clusters list, which in
+// turn wins over falling back to the current context.
+func ContextsFromFlags(cmd *cobra.Command, allContexts []string, currentContext string) []string {
+	if all, _ := cmd.Flags().GetBool("all-contexts"); all {
+		return allContexts
+	}
+	clusters, _ := cmd.Flags().GetString("clusters")
+	if len(clusters) == 0 {
+		return []string{currentContext}
+	}
+	return strings.Split(clusters, ",")
+}
+
+// contextWriter prefixes every line written to it with "[context] ", so
+// output from several clusters can be told apart when interleaved.
+type contextWriter struct {
+	context string
+	out     io.Writer
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w.out, "[%s] %s\n", w.context, line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// MultiClusterResource fans a single logical Delete/Get/Apply out across the
+// clients supplied in Clients, running each concurrently and aggregating
+// per-cluster errors. Output from each cluster is prefixed with its context
+// name via contextWriter.
+type MultiClusterResource struct {
+	Clients   []NamedRESTClient
+	Resources []kindedResource
+}
+
+func newMultiClusterResourceInfo(clients []NamedRESTClient, mapping *meta.RESTMapping, namespace, name string, data []byte) *MultiClusterResource {
+	resources := make([]kindedResource, 0, len(clients))
+	for _, c := range clients {
+		resources = append(resources, NewResourceInfo(c.Client, mapping, namespace, name).SetData(data))
+	}
+	return &MultiClusterResource{Clients: clients, Resources: resources}
+}
+
+func newMultiClusterResource(clients []NamedRESTClient, resources []kindedResource) *MultiClusterResource {
+	return &MultiClusterResource{Clients: clients, Resources: resources}
+}
+
+// Kind returns the wrapped resources' common kind.
+func (m *MultiClusterResource) Kind() string {
+	if len(m.Resources) == 0 {
+		return ""
+	}
+	return m.Resources[0].Kind()
+}
+
+type multiClusterResult struct {
+	context string
+	err     error
+}
+
+// run executes fn against every wrapped resource concurrently, one goroutine
+// per cluster, and aggregates any failures into a single error that names
+// every cluster that failed.
+func (m *MultiClusterResource) run(out io.Writer, fn func(kindedResource, io.Writer) error) error {
+	results := make(chan multiClusterResult, len(m.Resources))
+	for i, r := range m.Resources {
+		go func(i int, r kindedResource) {
+			w := contextWriter{context: m.Clients[i].Context, out: out}
+			results <- multiClusterResult{context: m.Clients[i].Context, err: fn(r, w)}
+		}(i, r)
+	}
+	var errs []string
+	for range m.Resources {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", res.context, res.err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed against %d of %d cluster(s):\n%s", len(errs), len(m.Resources), strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func (m *MultiClusterResource) Delete(out io.Writer) error {
+	return m.run(out, func(r kindedResource, w io.Writer) error { return r.Delete(w) })
+}
+
+func (m *MultiClusterResource) Apply(out io.Writer) error {
+	return m.run(out, func(r kindedResource, w io.Writer) error { return r.Apply(w) })
+}
+
+// Get fans the read out to every cluster concurrently for its side effect of
+// printing prefixed output, but returns only the first cluster's object,
+// since there is no single runtime.Object that could represent results from
+// several clusters at once.
+func (m *MultiClusterResource) Get(out io.Writer) (runtime.Object, error) {
+	if len(m.Resources) == 0 {
+		return nil, fmt.Errorf("no clusters to get from")
+	}
+	type getResult struct {
+		context string
+		obj     runtime.Object
+		err     error
+	}
+	results := make(chan getResult, len(m.Resources))
+	for i, r := range m.Resources {
+		go func(i int, r kindedResource) {
+			w := contextWriter{context: m.Clients[i].Context, out: out}
+			obj, err := r.Get(w)
+			results <- getResult{context: m.Clients[i].Context, obj: obj, err: err}
+		}(i, r)
+	}
+	byContext := make(map[string]getResult, len(m.Resources))
+	var errs []string
+	for range m.Resources {
+		res := <-results
+		byContext[res.context] = res
+		if res.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", res.context, res.err))
+		}
+	}
+	first := byContext[m.Clients[0].Context]
+	if len(errs) > 0 {
+		return first.obj, fmt.Errorf("failed against %d of %d cluster(s):\n%s", len(errs), len(m.Resources), strings.Join(errs, "\n"))
+	}
+	return first.obj, nil
+}
+
 // ResourceFromArgsOrFile expects two arguments or a valid file with a given type, and extracts
 // the fields necessary to uniquely locate a resource. Displays a usageError if that contract is
-// not satisfied, or a generic error if any other problems occur.
-func ResourceFromArgsOrFile(cmd *cobra.Command, args []string, filename string, typer runtime.ObjectTyper, mapper meta.RESTMapper, schema validation.Schema) (mapping *meta.RESTMapping, namespace, name string) {
+// not satisfied, or a generic error if any other problems occur. data is only populated when the
+// resource came from a file, and is what Apply uses to reconcile the live object. plugin is
+// non-nil when resource resolves to a plugin-only kind the core RESTMapper does not know about,
+// in which case mapping, namespace, name and data should be ignored in favor of it.
+func ResourceFromArgsOrFile(cmd *cobra.Command, args []string, filename string, typer runtime.ObjectTyper, mapper meta.RESTMapper, schema validation.Schema) (mapping *meta.RESTMapping, namespace, name string, data []byte, plugin *PluginResource) {
 	// If command line args are passed in, use those preferentially.
 	if len(args) > 0 && len(args) != 2 {
 		usageError(cmd, "If passing in command line parameters, must be resource and name")
 	}
 
 	if len(args) == 2 {
-		resource := kubectl.ExpandResourceShortcut(args[0])
+		resource := expandResourceShortcut(args[0])
 		namespace = GetKubeNamespace(cmd)
 		name = args[1]
 		if len(name) == 0 || len(resource) == 0 {
@@ -157,6 +657,11 @@ func ResourceFromArgsOrFile(cmd *cobra.Command, args []string, filename string,
 
 		version, kind, err := mapper.VersionAndKindForResource(resource)
 		if err != nil {
+			if p, ok := plugins.Lookup(resource); ok {
+				plugin = newPluginResource(p, namespace, nil)
+				plugin.Name = name
+				return
+			}
 			// The error returned by mapper is "no resource defined", which is a usage error
 			usageError(cmd, err.Error())
 		}
@@ -170,7 +675,10 @@ func ResourceFromArgsOrFile(cmd *cobra.Command, args []string, filename string,
 		usageError(cmd, "Must specify filename or command line params")
 	}
 
-	mapping, namespace, name, _ = ResourceFromFile(filename, typer, mapper, schema)
+	mapping, namespace, name, data, plugin = ResourceFromFile(filename, typer, mapper, schema)
+	if plugin != nil {
+		return
+	}
 	if len(name) == 0 {
 		checkErr(fmt.Errorf("the resource in the provided file has no name (or ID) defined"))
 	}
@@ -186,7 +694,7 @@ func ResourceFromArgs(cmd *cobra.Command, args []string, mapper meta.RESTMapper)
 		usageError(cmd, "Must provide resource and name command line params")
 	}
 
-	resource := kubectl.ExpandResourceShortcut(args[0])
+	resource := expandResourceShortcut(args[0])
 	namespace = GetKubeNamespace(cmd)
 	name = args[1]
 	if len(name) == 0 || len(resource) == 0 {
@@ -209,7 +717,7 @@ func ResourceOrTypeFromArgs(cmd *cobra.Command, args []string, mapper meta.RESTM
 		usageError(cmd, "Must provide resource or a resource and name as command line params")
 	}
 
-	resource := kubectl.ExpandResourceShortcut(args[0])
+	resource := expandResourceShortcut(args[0])
 	if len(resource) == 0 {
 		usageError(cmd, "Must provide resource or a resource and name as command line params")
 	}
@@ -233,12 +741,52 @@ func ResourceOrTypeFromArgs(cmd *cobra.Command, args []string, mapper meta.RESTM
 
 // ResourceFromFile retrieves the name and namespace from a valid file. If the file does not
 // resolve to a known type an error is returned. The returned mapping can be used to determine
-// the correct REST endpoint to modify this resource with.
-func ResourceFromFile(filename string, typer runtime.ObjectTyper, mapper meta.RESTMapper, schema validation.Schema) (mapping *meta.RESTMapping, namespace, name string, data []byte) {
+// the correct REST endpoint to modify this resource with. plugin is non-nil when kind resolves
+// to a plugin-only kind the core RESTMapper does not know about, in which case mapping, namespace
+// and name should be ignored in favor of it. If the file holds more than one document, only the
+// first is returned; use ResourcesFromFile to get them all.
+func ResourceFromFile(filename string, typer runtime.ObjectTyper, mapper meta.RESTMapper, schema validation.Schema) (mapping *meta.RESTMapping, namespace, name string, data []byte, plugin *PluginResource) {
 	configData, err := ReadConfigData(filename)
 	checkErr(err)
-	data = configData
+	docs := splitDocuments(configData)
+	data = docs[0]
+	mapping, namespace, name, plugin = resourceFromData(data, typer, mapper, schema)
+	return
+}
 
+// fileResource is one decoded document from a (possibly multi-document)
+// manifest file, as returned by ResourcesFromFile.
+type fileResource struct {
+	Mapping   *meta.RESTMapping
+	Namespace string
+	Name      string
+	Data      []byte
+	Plugin    *PluginResource
+}
+
+// ResourcesFromFile reads filename and decodes every document in it - a
+// plain file holds exactly one, a multi-document YAML/JSON file (documents
+// separated by a line containing only "---") holds one per document -
+// returning one fileResource per document in file order, so callers can feed
+// them all through OrderResources the same way expanded label selectors
+// already are.
+func ResourcesFromFile(filename string, typer runtime.ObjectTyper, mapper meta.RESTMapper, schema validation.Schema) []fileResource {
+	configData, err := ReadConfigData(filename)
+	checkErr(err)
+
+	docs := splitDocuments(configData)
+	resources := make([]fileResource, 0, len(docs))
+	for _, doc := range docs {
+		mapping, namespace, name, plugin := resourceFromData(doc, typer, mapper, schema)
+		resources = append(resources, fileResource{Mapping: mapping, Namespace: namespace, Name: name, Data: doc, Plugin: plugin})
+	}
+	return resources
+}
+
+// resourceFromData is the per-document body of ResourceFromFile/
+// ResourcesFromFile: decode one already-split document and extract its
+// mapping, namespace and name (or a PluginResource, for a plugin-only kind).
+func resourceFromData(data []byte, typer runtime.ObjectTyper, mapper meta.RESTMapper, schema validation.Schema) (mapping *meta.RESTMapping, namespace, name string, plugin *PluginResource) {
 	version, kind, err := typer.DataVersionAndKind(data)
 	checkErr(err)
 
@@ -247,11 +795,22 @@ func ResourceFromFile(filename string, typer runtime.ObjectTyper, mapper meta.RE
 		checkErr(fmt.Errorf("the resource in the provided file has no apiVersion defined"))
 	}
 
-	err = schema.ValidateBytes(data)
-	checkErr(err)
-
 	mapping, err = mapper.RESTMapping(version, kind)
-	checkErr(err)
+	if err != nil {
+		p, ok := plugins.Lookup(kind)
+		if !ok {
+			checkErr(err)
+		}
+		namespace, name, err = unstructuredNameAndNamespace(data)
+		checkErr(err)
+		mapping = nil
+		plugin = newPluginResource(p, namespace, nil)
+		plugin.Name = name
+		plugin.SetData(data)
+		return
+	}
+
+	checkErr(schema.ValidateBytes(data))
 
 	obj, err := mapping.Codec.Decode(data)
 	checkErr(err)
@@ -265,6 +824,55 @@ func ResourceFromFile(filename string, typer runtime.ObjectTyper, mapper meta.RE
 	return
 }
 
+// splitDocuments splits raw file content on a line containing only "---",
+// YAML's document separator, into one or more documents, each trimmed of
+// surrounding whitespace, discarding any that are empty after trimming (such
+// as those a leading or trailing separator produces). A file with no
+// separator at all (a plain single JSON or YAML object, the common case)
+// comes back as a single-element slice holding that trimmed content. If no
+// non-empty document remains (an empty file, or one that is just "---"), the
+// result is a single-element slice holding data as given.
+func splitDocuments(data []byte) [][]byte {
+	var docs [][]byte
+	var current bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "---" {
+			if doc := bytes.TrimSpace(current.Bytes()); len(doc) > 0 {
+				docs = append(docs, append([]byte(nil), doc...))
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteByte('\n')
+	}
+	if doc := bytes.TrimSpace(current.Bytes()); len(doc) > 0 {
+		docs = append(docs, append([]byte(nil), doc...))
+	}
+	if len(docs) == 0 {
+		docs = [][]byte{data}
+	}
+	return docs
+}
+
+// unstructuredNameAndNamespace extracts metadata.name and metadata.namespace
+// directly from a resource's raw JSON, for plugin-only kinds whose data the
+// core Codec/MetadataAccessor pair does not know how to decode.
+func unstructuredNameAndNamespace(data []byte) (namespace, name string, err error) {
+	var obj struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return "", "", fmt.Errorf("decoding metadata: %v", err)
+	}
+	return obj.Metadata.Namespace, obj.Metadata.Name, nil
+}
+
 // CompareNamespaceFromFile returns an error if the namespace the user has provided on the CLI
 // or via the default namespace file does not match the namespace of an input file. This
 // prevents a user from unintentionally updating the wrong namespace.
@@ -291,3 +899,238 @@ func SplitResourceArgument(arg string, mapper meta.RESTMapper) []string {
 	}
 	return set.List()
 }
+
+// kindedResource is implemented by the Resource types that know their own
+// kind, which is everything but a MultiClusterResource wrapping them.
+type kindedResource interface {
+	Resource
+	Kind() string
+}
+
+// defaultKindOrder is the priority OrderedResourceSet applies resources in
+// when the caller does not supply its own KindOrder. It follows the
+// dependency order used by cli-runtime-based sync tools: resources that
+// other resources refer to (namespaces, CRDs, credentials and config) come
+// first, then storage, Services, the workload controllers and Ingress, with
+// Jobs last.
+var defaultKindOrder = []string{
+	"Namespace",
+	"CustomResourceDefinition",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"PersistentVolumeClaim",
+	"PersistentVolume",
+	"Service",
+	"ReplicationController",
+	"Deployment",
+	"Ingress",
+	"Job",
+}
+
+// OrderedResourceSet applies or deletes a batch of resources in a
+// deterministic, dependency-aware order instead of the order they were
+// supplied in (e.g. the order a multi-doc file or an expanded selector
+// happened to produce).
+type OrderedResourceSet struct {
+	Resources []kindedResource
+	// KindOrder gives the priority of each kind, lowest index first. Kinds
+	// not present in KindOrder sort after everything that is. Operators may
+	// override this to account for their own CRDs and conventions.
+	KindOrder []string
+	// PollInterval and Timeout bound how long Apply waits for a resource to
+	// become ready before moving on to the next one.
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
+// NewOrderedResourceSet builds an OrderedResourceSet using the default kind
+// priority and a 1s/30s poll interval/timeout.
+func NewOrderedResourceSet(resources []kindedResource) *OrderedResourceSet {
+	return &OrderedResourceSet{
+		Resources:    resources,
+		KindOrder:    defaultKindOrder,
+		PollInterval: time.Second,
+		Timeout:      30 * time.Second,
+	}
+}
+
+// resourceSetFrom builds an OrderedResourceSet out of a plain []Resource,
+// keeping only the elements that know their own Kind (every Resource type
+// this package defines does; a future one that doesn't is simply left out
+// of ordering rather than causing an error).
+func resourceSetFrom(resources []Resource) *OrderedResourceSet {
+	kinded := make([]kindedResource, 0, len(resources))
+	for _, r := range resources {
+		if k, ok := r.(kindedResource); ok {
+			kinded = append(kinded, k)
+		}
+	}
+	return NewOrderedResourceSet(kinded)
+}
+
+// OrderResources sorts resources by kind priority (Namespace, CustomResource
+// Definitions, credentials, ... before the workloads that depend on them),
+// the same order OrderedResourceSet.Apply uses, without its wait-for-ready
+// semantics. ResourcesFromArgsOrFile and ResourcesFromDir call this so
+// multi-resource file/selector expansions come back in dependency order
+// instead of file/arg order.
+func OrderResources(resources []Resource) []Resource {
+	set := resourceSetFrom(resources)
+	ordered := make([]Resource, 0, len(resources))
+	for _, i := range set.order(false) {
+		ordered = append(ordered, set.Resources[i])
+	}
+	return ordered
+}
+
+// ApplyOrdered applies every element of resources through an
+// OrderedResourceSet, so a multi-resource apply follows kind priority and
+// waits for each resource to become ready before moving on to the next,
+// instead of applying resources in whatever order the caller built them in.
+func ApplyOrdered(resources []Resource, out io.Writer) error {
+	return resourceSetFrom(resources).Apply(out)
+}
+
+// DeleteOrdered is the Delete counterpart of ApplyOrdered: it tears
+// resources down in the reverse of kind priority order, so e.g. a Service
+// is gone before its Namespace.
+func DeleteOrdered(resources []Resource, out io.Writer) error {
+	return resourceSetFrom(resources).Delete(out)
+}
+
+func (o *OrderedResourceSet) priority(kind string) int {
+	for i, k := range o.KindOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(o.KindOrder)
+}
+
+// byPriority sorts indexes into a resource list by ascending kind priority.
+type byPriority struct {
+	indexes  []int
+	kinds    []string
+	set      *OrderedResourceSet
+	reversed bool
+}
+
+func (b *byPriority) Len() int      { return len(b.indexes) }
+func (b *byPriority) Swap(i, j int) { b.indexes[i], b.indexes[j] = b.indexes[j], b.indexes[i] }
+func (b *byPriority) Less(i, j int) bool {
+	pi, pj := b.set.priority(b.kinds[b.indexes[i]]), b.set.priority(b.kinds[b.indexes[j]])
+	if b.reversed {
+		return pi > pj
+	}
+	return pi < pj
+}
+
+// order returns indexes into o.Resources sorted by kind priority; reverse
+// flips the comparison, which Delete uses so dependents are torn down
+// before what they depend on.
+func (o *OrderedResourceSet) order(reverse bool) []int {
+	indexes := make([]int, len(o.Resources))
+	kinds := make([]string, len(o.Resources))
+	for i, r := range o.Resources {
+		indexes[i] = i
+		kinds[i] = r.Kind()
+	}
+	sort.Stable(&byPriority{indexes: indexes, kinds: kinds, set: o, reversed: reverse})
+	return indexes
+}
+
+// Apply applies every resource in dependency order, waiting for each one to
+// become ready before moving on to the next.
+func (o *OrderedResourceSet) Apply(out io.Writer) error {
+	for _, i := range o.order(false) {
+		r := o.Resources[i]
+		if err := r.Apply(out); err != nil {
+			return fmt.Errorf("applying %s: %v", r.Kind(), err)
+		}
+		if err := o.waitForReady(r); err != nil {
+			return fmt.Errorf("waiting for %s to become ready: %v", r.Kind(), err)
+		}
+	}
+	return nil
+}
+
+// Delete removes every resource in reverse dependency order, so a Service is
+// gone before its Namespace, for example.
+func (o *OrderedResourceSet) Delete(out io.Writer) error {
+	for _, i := range o.order(true) {
+		if err := o.Resources[i].Delete(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForReady polls the resource with Get until isReady reports it ready,
+// or the timeout elapses. Existing is not the same as ready: a freshly
+// created Deployment's Get succeeds immediately, long before its replicas
+// are up, so this inspects the object's own status rather than just
+// checking that Get returns without error.
+func (o *OrderedResourceSet) waitForReady(r Resource) error {
+	deadline := time.Now().Add(o.Timeout)
+	for {
+		obj, err := r.Get(ioutil.Discard)
+		if err == nil {
+			ready, rerr := isReady(obj)
+			if rerr == nil && ready {
+				return nil
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("timed out after %v", o.Timeout)
+		}
+		time.Sleep(o.PollInterval)
+	}
+}
+
+// readinessStatus is the subset of a typical status stanza isReady looks
+// at: either a set of conditions (Deployment, Pod, Node, ...) or a bare
+// replica count (ReplicationController, ReplicaSet on older APIs that
+// predate conditions).
+type readinessStatus struct {
+	Spec struct {
+		Replicas *int32 `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		Replicas      int32 `json:"replicas"`
+		ReadyReplicas int32 `json:"readyReplicas"`
+		Conditions    []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// isReady inspects obj's generic JSON shape for common readiness signals: a
+// True "Ready" or "Available" condition, or status.readyReplicas having
+// caught up with spec.replicas for controllers that expose replica counts
+// instead of conditions. Kinds with neither (ConfigMap, Secret, Service,
+// ...) are considered ready as soon as they exist, since there is nothing
+// further to wait for.
+func isReady(obj runtime.Object) (bool, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return false, err
+	}
+	var status readinessStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return false, err
+	}
+	if len(status.Status.Conditions) > 0 {
+		for _, c := range status.Status.Conditions {
+			if (c.Type == "Ready" || c.Type == "Available") && c.Status == "True" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if status.Spec.Replicas != nil {
+		return status.Status.ReadyReplicas >= *status.Spec.Replicas, nil
+	}
+	return true, nil
+}
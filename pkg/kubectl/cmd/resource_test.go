@@ -0,0 +1,396 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubectl/plugins"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+)
+
+// fakeKindedResource is a minimal kindedResource stand-in for exercising
+// ordering without any real REST client.
+type fakeKindedResource struct {
+	kind string
+}
+
+func (f *fakeKindedResource) Kind() string            { return f.kind }
+func (f *fakeKindedResource) Delete(io.Writer) error  { return nil }
+func (f *fakeKindedResource) Apply(io.Writer) error   { return nil }
+func (f *fakeKindedResource) Get(io.Writer) (runtime.Object, error) {
+	return nil, fmt.Errorf("fakeKindedResource %s has no backing object", f.kind)
+}
+
+func kinds(resources []Resource) []string {
+	out := make([]string, len(resources))
+	for i, r := range resources {
+		out[i] = r.(kindedResource).Kind()
+	}
+	return out
+}
+
+func TestOrderResources(t *testing.T) {
+	resources := []Resource{
+		&fakeKindedResource{kind: "Deployment"},
+		&fakeKindedResource{kind: "Service"},
+		&fakeKindedResource{kind: "Namespace"},
+		&fakeKindedResource{kind: "ConfigMap"},
+		&fakeKindedResource{kind: "Widget"}, // not in defaultKindOrder
+	}
+	got := kinds(OrderResources(resources))
+	want := []string{"Namespace", "ConfigMap", "Service", "Deployment", "Widget"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("OrderResources order = %v, want %v", got, want)
+	}
+}
+
+func TestOrderedResourceSetDeleteReversesOrder(t *testing.T) {
+	set := NewOrderedResourceSet([]kindedResource{
+		&fakeKindedResource{kind: "Deployment"},
+		&fakeKindedResource{kind: "Namespace"},
+		&fakeKindedResource{kind: "Service"},
+	})
+	applyOrder := set.order(false)
+	deleteOrder := set.order(true)
+	if len(applyOrder) != len(deleteOrder) {
+		t.Fatalf("order lengths differ: %d vs %d", len(applyOrder), len(deleteOrder))
+	}
+	for i, j := 0, len(deleteOrder)-1; i < len(applyOrder); i, j = i+1, j-1 {
+		if applyOrder[i] != deleteOrder[j] {
+			t.Fatalf("delete order is not the reverse of apply order: apply=%v delete=%v", applyOrder, deleteOrder)
+		}
+	}
+}
+
+// slowResource sleeps for delay before returning, so tests can tell a
+// concurrent fan-out (total time ~= the slowest one) apart from a serial
+// one (total time ~= the sum of all of them).
+type slowResource struct {
+	kind  string
+	delay time.Duration
+	fail  bool
+}
+
+func (s *slowResource) Kind() string           { return s.kind }
+func (s *slowResource) Delete(io.Writer) error { return nil }
+func (s *slowResource) Apply(io.Writer) error  { return nil }
+func (s *slowResource) Get(io.Writer) (runtime.Object, error) {
+	time.Sleep(s.delay)
+	if s.fail {
+		return nil, fmt.Errorf("%s: simulated failure", s.kind)
+	}
+	return nil, nil
+}
+
+func TestMultiClusterResourceGetRunsConcurrently(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	m := &MultiClusterResource{
+		Clients: []NamedRESTClient{{Context: "a"}, {Context: "b"}, {Context: "c"}},
+		Resources: []kindedResource{
+			&slowResource{kind: "Pod", delay: delay},
+			&slowResource{kind: "Pod", delay: delay},
+			&slowResource{kind: "Pod", delay: delay},
+		},
+	}
+	start := time.Now()
+	if _, err := m.Get(ioutil.Discard); err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 3*delay {
+		t.Fatalf("Get took %v, which looks serial (3x%v); want roughly %v if run concurrently", elapsed, delay, delay)
+	}
+}
+
+func TestMultiClusterResourceGetAggregatesErrors(t *testing.T) {
+	m := &MultiClusterResource{
+		Clients: []NamedRESTClient{{Context: "a"}, {Context: "b"}},
+		Resources: []kindedResource{
+			&slowResource{kind: "Pod"},
+			&slowResource{kind: "Pod", fail: true},
+		},
+	}
+	_, err := m.Get(ioutil.Discard)
+	if err == nil {
+		t.Fatal("expected an error when one cluster fails, got nil")
+	}
+}
+
+// statusObject is a bare-bones runtime.Object whose JSON shape isReady
+// inspects; it carries no other kubectl machinery.
+type statusObject struct {
+	Spec struct {
+		Replicas *int32 `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		Replicas      int32 `json:"replicas"`
+		ReadyReplicas int32 `json:"readyReplicas"`
+		Conditions    []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+func (*statusObject) IsAnAPIObject() {}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestIsReady(t *testing.T) {
+	cases := []struct {
+		name string
+		obj  *statusObject
+		want bool
+	}{
+		{
+			name: "no status signals at all is ready",
+			obj:  &statusObject{},
+			want: true,
+		},
+		{
+			name: "replicas caught up is ready",
+			obj: func() *statusObject {
+				o := &statusObject{}
+				o.Spec.Replicas = int32Ptr(3)
+				o.Status.ReadyReplicas = 3
+				return o
+			}(),
+			want: true,
+		},
+		{
+			name: "replicas not caught up is not ready",
+			obj: func() *statusObject {
+				o := &statusObject{}
+				o.Spec.Replicas = int32Ptr(3)
+				o.Status.ReadyReplicas = 1
+				return o
+			}(),
+			want: false,
+		},
+		{
+			name: "true Ready condition is ready",
+			obj: func() *statusObject {
+				o := &statusObject{}
+				o.Status.Conditions = append(o.Status.Conditions, struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				}{Type: "Ready", Status: "True"})
+				return o
+			}(),
+			want: true,
+		},
+		{
+			name: "false Ready condition is not ready",
+			obj: func() *statusObject {
+				o := &statusObject{}
+				o.Status.Conditions = append(o.Status.Conditions, struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				}{Type: "Ready", Status: "False"})
+				return o
+			}(),
+			want: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := isReady(c.obj)
+			if err != nil {
+				t.Fatalf("isReady returned error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("isReady = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// fakeResourcePlugin is a minimal plugins.ResourcePlugin for exercising
+// PluginResource without a real .so-backed plugin.
+type fakeResourcePlugin struct {
+	kind        string
+	shortNames  []string
+	createCalls [][]byte
+	deleted     string
+	gotName     string
+}
+
+func (p *fakeResourcePlugin) Kind() string        { return p.kind }
+func (p *fakeResourcePlugin) ShortNames() []string { return p.shortNames }
+func (p *fakeResourcePlugin) Create(data []byte, ns string) (runtime.Object, error) {
+	p.createCalls = append(p.createCalls, data)
+	return nil, nil
+}
+func (p *fakeResourcePlugin) Get(ns, name string) (runtime.Object, error) {
+	p.gotName = name
+	return nil, nil
+}
+func (p *fakeResourcePlugin) Delete(ns, name string) error {
+	p.deleted = name
+	return nil
+}
+func (p *fakeResourcePlugin) List(ns string, sel labels.Selector) (runtime.Object, error) {
+	return nil, nil
+}
+
+// fakeUpdatingResourcePlugin additionally implements plugins.ResourceUpdater,
+// for exercising PluginResource.Apply's update path.
+type fakeUpdatingResourcePlugin struct {
+	fakeResourcePlugin
+	exists      bool
+	updateCalls [][]byte
+}
+
+func (p *fakeUpdatingResourcePlugin) Get(ns, name string) (runtime.Object, error) {
+	if !p.exists {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	return p.fakeResourcePlugin.Get(ns, name)
+}
+
+func (p *fakeUpdatingResourcePlugin) Update(data []byte, ns, name string) (runtime.Object, error) {
+	p.updateCalls = append(p.updateCalls, data)
+	return nil, nil
+}
+
+func TestExpandResourceShortcutPrefersPluginRegistry(t *testing.T) {
+	plugins.Register(&fakeResourcePlugin{kind: "Widget", shortNames: []string{"wd"}})
+
+	if got := expandResourceShortcut("wd"); got != "Widget" {
+		t.Fatalf("expandResourceShortcut(%q) = %q, want Widget", "wd", got)
+	}
+	if got := expandResourceShortcut("po"); got != "pods" {
+		t.Fatalf("expandResourceShortcut(%q) = %q, want kubectl's built-in pods expansion", "po", got)
+	}
+}
+
+func TestPluginResourceApplyCreatesWithData(t *testing.T) {
+	// exists is false, so Get reports the resource missing and Apply creates it.
+	p := &fakeUpdatingResourcePlugin{fakeResourcePlugin: fakeResourcePlugin{kind: "Widget"}}
+	r := newPluginResource(p, "default", nil)
+	r.Name = "my-widget"
+	r.SetData([]byte(`{"metadata":{"name":"my-widget"}}`))
+
+	var out bytes.Buffer
+	if err := r.Apply(&out); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(p.createCalls) != 1 {
+		t.Fatalf("expected exactly one Create call, got %d", len(p.createCalls))
+	}
+}
+
+func TestPluginResourceApplyWithNoDataErrors(t *testing.T) {
+	p := &fakeResourcePlugin{kind: "Widget"}
+	r := newPluginResource(p, "default", nil)
+	r.Name = "my-widget"
+
+	if err := r.Apply(ioutil.Discard); err == nil {
+		t.Fatalf("expected Apply with no data to error")
+	}
+}
+
+func TestPluginResourceApplyUpdatesExistingResourceViaResourceUpdater(t *testing.T) {
+	p := &fakeUpdatingResourcePlugin{fakeResourcePlugin: fakeResourcePlugin{kind: "Widget"}, exists: true}
+	r := newPluginResource(p, "default", nil)
+	r.Name = "my-widget"
+	r.SetData([]byte(`{"metadata":{"name":"my-widget"}}`))
+
+	var out bytes.Buffer
+	if err := r.Apply(&out); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(p.updateCalls) != 1 {
+		t.Fatalf("expected exactly one Update call, got %d", len(p.updateCalls))
+	}
+	if len(p.createCalls) != 0 {
+		t.Fatalf("expected no Create call when the resource already exists, got %d", len(p.createCalls))
+	}
+}
+
+func TestPluginResourceApplyOnExistingResourceWithoutUpdaterErrors(t *testing.T) {
+	p := &fakeResourcePlugin{kind: "Widget"}
+	r := newPluginResource(p, "default", nil)
+	r.Name = "my-widget"
+	r.SetData([]byte(`{"metadata":{"name":"my-widget"}}`))
+
+	// fakeResourcePlugin's zero-value Get succeeds (it only records gotName),
+	// simulating a plugin whose resource already exists but which does not
+	// implement plugins.ResourceUpdater.
+	if err := r.Apply(ioutil.Discard); err == nil {
+		t.Fatalf("expected Apply to report the create-only limitation instead of calling Create again")
+	}
+	if len(p.createCalls) != 0 {
+		t.Fatalf("expected no Create call for an existing resource with no update path, got %d", len(p.createCalls))
+	}
+}
+
+func TestPluginResourceDeleteByName(t *testing.T) {
+	p := &fakeResourcePlugin{kind: "Widget"}
+	r := newPluginResource(p, "default", nil)
+	r.Name = "my-widget"
+
+	if err := r.Delete(ioutil.Discard); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if p.deleted != "my-widget" {
+		t.Fatalf("Plugin.Delete called with name %q, want my-widget", p.deleted)
+	}
+}
+
+func TestPluginResourceDeleteBySelectorIsRejected(t *testing.T) {
+	p := &fakeResourcePlugin{kind: "Widget"}
+	r := newPluginResource(p, "default", labels.Everything())
+
+	if err := r.Delete(ioutil.Discard); err == nil {
+		t.Fatalf("expected Delete by label selector to be rejected for plugin-backed resources")
+	}
+}
+
+func TestSplitDocumentsSingleDocumentIsUnchanged(t *testing.T) {
+	data := []byte(`{"kind":"Pod"}`)
+	docs := splitDocuments(data)
+	if len(docs) != 1 || string(docs[0]) != string(data) {
+		t.Fatalf("splitDocuments(%q) = %q, want a single unchanged document", data, docs)
+	}
+}
+
+func TestSplitDocumentsMultiDocument(t *testing.T) {
+	data := []byte("kind: Pod\nmetadata:\n  name: a\n---\nkind: Pod\nmetadata:\n  name: b\n")
+	docs := splitDocuments(data)
+	if len(docs) != 2 {
+		t.Fatalf("splitDocuments returned %d documents, want 2: %q", len(docs), docs)
+	}
+	if !bytes.Contains(docs[0], []byte("name: a")) || !bytes.Contains(docs[1], []byte("name: b")) {
+		t.Fatalf("splitDocuments documents in wrong order or missing content: %q", docs)
+	}
+}
+
+func TestSplitDocumentsIgnoresLeadingTrailingSeparators(t *testing.T) {
+	data := []byte("---\nkind: Pod\n---\n")
+	docs := splitDocuments(data)
+	if len(docs) != 1 || !bytes.Contains(docs[0], []byte("kind: Pod")) {
+		t.Fatalf("splitDocuments(%q) = %q, want a single document with no empty entries", data, docs)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package builtin registers kubectl's own resource kinds - pod, service,
+// replicationcontroller, and so on - as plugins.ResourcePlugin entries, so
+// the same registry that serves third-party CRDs also serves the kinds
+// kubectl has always known about. This exists to prove the plugin surface
+// is sufficient for kubectl's own kinds; the actual Create/Get/Delete/List
+// work for these kinds still goes through the core RESTMapper and
+// RESTHelper in pkg/kubectl, not through this plugin's methods.
+package builtin
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubectl/plugins"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+)
+
+func init() {
+	plugins.Register(kindPlugin{kind: "pods", shortNames: []string{"po", "pods"}})
+	plugins.Register(kindPlugin{kind: "services", shortNames: []string{"svc", "services"}})
+	plugins.Register(kindPlugin{kind: "replicationcontrollers", shortNames: []string{"rc", "replicationcontrollers"}})
+}
+
+// kindPlugin registers a built-in kind for short-name resolution only; it
+// defers the actual REST operations to the core RESTMapper/RESTHelper path,
+// since those already know how to serve these kinds. kind is the lowercase
+// plural REST resource name (e.g. "pods"), not the capitalized Go kind
+// ("Pod"): expandResourceShortcut in pkg/kubectl/cmd passes Kind() straight
+// into mapper.VersionAndKindForResource, which expects a resource name, so
+// registering the capitalized kind here would break "kubectl get po".
+type kindPlugin struct {
+	kind       string
+	shortNames []string
+}
+
+func (k kindPlugin) Kind() string         { return k.kind }
+func (k kindPlugin) ShortNames() []string { return k.shortNames }
+
+func (k kindPlugin) Create(data []byte, ns string) (runtime.Object, error) {
+	return nil, fmt.Errorf("%s is a built-in kind; create it through the core resource path, not the plugin registry", k.kind)
+}
+
+func (k kindPlugin) Get(ns, name string) (runtime.Object, error) {
+	return nil, fmt.Errorf("%s is a built-in kind; get it through the core resource path, not the plugin registry", k.kind)
+}
+
+func (k kindPlugin) Delete(ns, name string) error {
+	return fmt.Errorf("%s is a built-in kind; delete it through the core resource path, not the plugin registry", k.kind)
+}
+
+func (k kindPlugin) List(ns string, sel labels.Selector) (runtime.Object, error) {
+	return nil, fmt.Errorf("%s is a built-in kind; list it through the core resource path, not the plugin registry", k.kind)
+}
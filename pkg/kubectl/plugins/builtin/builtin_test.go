@@ -0,0 +1,40 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/kubectl/plugins"
+)
+
+// TestShortNamesExpandToRESTResourceNames guards against registering the
+// capitalized Go kind as ExpandShortcut's result: callers feed that result
+// straight into mapper.VersionAndKindForResource, which expects a resource
+// name like "pods", not a Kind like "Pod".
+func TestShortNamesExpandToRESTResourceNames(t *testing.T) {
+	cases := map[string]string{
+		"po":  "pods",
+		"svc": "services",
+		"rc":  "replicationcontrollers",
+	}
+	for shortName, want := range cases {
+		if got := plugins.ExpandShortcut(shortName); got != want {
+			t.Errorf("ExpandShortcut(%q) = %q, want %q", shortName, got, want)
+		}
+	}
+}
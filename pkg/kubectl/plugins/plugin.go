@@ -0,0 +1,154 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugins lets third parties register new resource kinds - CRDs,
+// custom short names, custom create/get/delete/list handlers - at runtime,
+// instead of requiring every kind kubectl understands to be hardcoded into
+// the RESTMapper. Each kind lives in its own package implementing
+// ResourcePlugin and calls Register from an init function. kubectl's
+// resource-resolution path in pkg/kubectl/cmd consults the registry first
+// when expanding short names; for the kind itself it tries the built-in
+// RESTMapper first and consults the registry only when the RESTMapper does
+// not know the kind, so a plugin does not take over a kind the RESTMapper
+// already resolves.
+package plugins
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/golang/glog"
+)
+
+// ResourcePlugin lets a third party serve a resource kind that kubectl does
+// not know about natively: a CRD, a custom short name for an existing kind,
+// or an entirely custom backing store.
+type ResourcePlugin interface {
+	// Kind returns the canonical kind this plugin serves, e.g. "Widget".
+	Kind() string
+	// ShortNames returns the short names this plugin wants resolved to
+	// Kind(), e.g. []string{"wd", "widgets"}.
+	ShortNames() []string
+	Create(data []byte, ns string) (runtime.Object, error)
+	Get(ns, name string) (runtime.Object, error)
+	Delete(ns, name string) error
+	List(ns string, sel labels.Selector) (runtime.Object, error)
+}
+
+// ResourceUpdater is an optional capability a ResourcePlugin may implement
+// to reconcile a resource that already exists, the way `kubectl apply` does
+// for core kinds. A plugin that does not implement it only supports
+// create-on-first-apply; callers should report that limitation rather than
+// either erroring out of Create or silently creating a duplicate.
+type ResourceUpdater interface {
+	Update(data []byte, ns, name string) (runtime.Object, error)
+}
+
+var (
+	mu     sync.RWMutex
+	byKind = map[string]ResourcePlugin{}
+	byName = map[string]ResourcePlugin{}
+)
+
+// Register adds a plugin to the registry, indexed by its kind and every
+// short name it declares. A later call for the same kind or short name
+// replaces the earlier registration, so an operator can shadow a built-in
+// plugin (see pkg/kubectl/plugins/builtin) by registering their own.
+func Register(p ResourcePlugin) {
+	mu.Lock()
+	defer mu.Unlock()
+	byKind[p.Kind()] = p
+	byName[p.Kind()] = p
+	for _, name := range p.ShortNames() {
+		byName[name] = p
+	}
+}
+
+// Lookup resolves a resource name or short name to its registered plugin.
+// It returns false if nothing in the registry claims that name.
+func Lookup(nameOrKind string) (ResourcePlugin, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := byName[nameOrKind]
+	return p, ok
+}
+
+// ExpandShortcut resolves a short name to its plugin's full kind, mirroring
+// kubectl.ExpandResourceShortcut for registry-backed kinds. It returns ""
+// if no plugin claims the name.
+func ExpandShortcut(name string) string {
+	if p, ok := Lookup(name); ok {
+		return p.Kind()
+	}
+	return ""
+}
+
+// Names returns every resource name and short name currently registered,
+// sorted, for use by callers that need to expand "all".
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadDir loads every .so file in dir as a kubectl plugin. Each plugin must
+// export a symbol named "Plugin" of type ResourcePlugin (or a pointer to
+// one); it is registered automatically. Load errors for an individual file
+// are logged and skipped so one bad plugin does not prevent the rest, and
+// do not prevent startup from kubectl continuing with its built-ins.
+func LoadDir(dir string) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return fmt.Errorf("listing plugin directory %s: %v", dir, err)
+	}
+	for _, path := range matches {
+		if err := loadOne(path); err != nil {
+			glog.Errorf("Skipping plugin %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+func loadOne(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	sym, err := p.Lookup("Plugin")
+	if err != nil {
+		return err
+	}
+	rp, ok := sym.(ResourcePlugin)
+	if !ok {
+		if ptr, ok := sym.(*ResourcePlugin); ok {
+			rp = *ptr
+		} else {
+			return fmt.Errorf("exported symbol Plugin does not implement ResourcePlugin")
+		}
+	}
+	Register(rp)
+	return nil
+}
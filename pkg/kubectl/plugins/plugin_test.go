@@ -0,0 +1,159 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+)
+
+// fakePlugin is a minimal ResourcePlugin for exercising the registry
+// without a real .so file.
+type fakePlugin struct {
+	kind       string
+	shortNames []string
+}
+
+func (p fakePlugin) Kind() string         { return p.kind }
+func (p fakePlugin) ShortNames() []string { return p.shortNames }
+
+func (p fakePlugin) Create(data []byte, ns string) (runtime.Object, error) {
+	return nil, fmt.Errorf("fakePlugin %s does not support Create", p.kind)
+}
+
+func (p fakePlugin) Get(ns, name string) (runtime.Object, error) {
+	return nil, fmt.Errorf("fakePlugin %s does not support Get", p.kind)
+}
+
+func (p fakePlugin) Delete(ns, name string) error {
+	return fmt.Errorf("fakePlugin %s does not support Delete", p.kind)
+}
+
+func (p fakePlugin) List(ns string, sel labels.Selector) (runtime.Object, error) {
+	return nil, fmt.Errorf("fakePlugin %s does not support List", p.kind)
+}
+
+func resetRegistry() {
+	mu.Lock()
+	defer mu.Unlock()
+	byKind = map[string]ResourcePlugin{}
+	byName = map[string]ResourcePlugin{}
+}
+
+func TestRegisterAndLookupByKindAndShortName(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	Register(fakePlugin{kind: "Widget", shortNames: []string{"wd", "widgets"}})
+
+	for _, name := range []string{"Widget", "wd", "widgets"} {
+		p, ok := Lookup(name)
+		if !ok {
+			t.Fatalf("Lookup(%q) = not found, want the registered plugin", name)
+		}
+		if p.Kind() != "Widget" {
+			t.Fatalf("Lookup(%q).Kind() = %q, want Widget", name, p.Kind())
+		}
+	}
+
+	if _, ok := Lookup("gizmo"); ok {
+		t.Fatalf("Lookup(%q) unexpectedly found a plugin", "gizmo")
+	}
+}
+
+func TestRegisterReplacesEarlierRegistration(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	Register(fakePlugin{kind: "Widget", shortNames: []string{"wd"}})
+	Register(fakePlugin{kind: "Widget", shortNames: []string{"wd", "wdg"}})
+
+	p, ok := Lookup("wdg")
+	if !ok || p.Kind() != "Widget" {
+		t.Fatalf("Lookup(%q) = %v, %v, want the second registration's Widget plugin", "wdg", p, ok)
+	}
+}
+
+func TestExpandShortcut(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	Register(fakePlugin{kind: "Widget", shortNames: []string{"wd"}})
+
+	if got := ExpandShortcut("wd"); got != "Widget" {
+		t.Fatalf("ExpandShortcut(%q) = %q, want Widget", "wd", got)
+	}
+	if got := ExpandShortcut("nope"); len(got) != 0 {
+		t.Fatalf("ExpandShortcut(%q) = %q, want empty string for an unregistered name", "nope", got)
+	}
+}
+
+func TestNames(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	Register(fakePlugin{kind: "Widget", shortNames: []string{"wd", "widgets"}})
+	Register(fakePlugin{kind: "Gizmo", shortNames: []string{"gz"}})
+
+	got := Names()
+	want := []string{"Gizmo", "Widget", "gz", "wd", "widgets"}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Names() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadDirSkipsBadPlugins(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	dir, err := ioutil.TempDir("", "kubectl-plugins-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A file that looks like a plugin but is not a valid Go plugin binary;
+	// LoadDir must log and skip it rather than failing the whole load.
+	if err := ioutil.WriteFile(dir+"/bad.so", []byte("not a real plugin"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if err := LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir with an unloadable plugin present: %v", err)
+	}
+	if _, ok := Lookup("bad"); ok {
+		t.Fatalf("Lookup found a plugin for the file that failed to load")
+	}
+}
+
+func TestLoadDirMissingDirectoryIsNotAnError(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	if err := LoadDir("/does/not/exist/kubectl-plugins"); err != nil {
+		t.Fatalf("LoadDir on a missing directory: %v (filepath.Glob does not error on a missing dir, it just matches nothing)", err)
+	}
+}
@@ -14,7 +14,12 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Reads the configuration from the file. Example file for two services [nodejs & mysql]
+// Reads the configuration from a file or directory, watching it for changes
+// rather than polling. filename may name either a single file or a
+// directory; in the directory case every file directly inside it is
+// decoded and unioned together. The legacy custom JSON schema this package
+// originally supported still works; example for two services [nodejs &
+// mysql]:
 //{"Services": [
 //   {
 //      "Name":"nodejs",
@@ -28,19 +33,38 @@ limitations under the License.
 //   }
 //]
 //}
+// A file may also hold native api.Service/api.Endpoints objects, as
+// produced by kubectl; see ManifestConfig.
 package config
 
 import (
-	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/fsnotify/fsnotify"
 	"github.com/golang/glog"
 )
 
+// debounceInterval absorbs the burst of several fsnotify events (e.g. a
+// rename plus a write) that many editors emit for what is logically a
+// single save, so a single edit triggers a single reload.
+const debounceInterval = 100 * time.Millisecond
+
+// watchRetryInterval and watchRetryMax bound the backoff waitForWatch uses
+// while s.filename does not exist yet: it doubles the wait after each
+// failed watcher.Add, starting at watchRetryInterval and never waiting
+// longer than watchRetryMax between attempts.
+const (
+	watchRetryInterval = 100 * time.Millisecond
+	watchRetryMax      = 30 * time.Second
+)
+
 // TODO: kill this struct.
 type ServiceJSON struct {
 	Name      string
@@ -51,64 +75,233 @@ type ConfigFile struct {
 	Services []ServiceJSON
 }
 
+// ManifestConfig is the shape a native manifest takes: a list of Service
+// objects and a parallel list of Endpoints objects, each already in the
+// canonical api wire format rather than the legacy ad hoc schema above.
+type ManifestConfig struct {
+	Services  []api.Service
+	Endpoints []api.Endpoints
+}
+
+// Decoder turns the raw bytes of one config file into services and
+// endpoints. ConfigSourceFile tries decoders in order and uses the first
+// one that succeeds, so a source can accept more than one schema without
+// the caller choosing up front.
+type Decoder interface {
+	Decode(data []byte) ([]api.Service, []api.Endpoints, error)
+}
+
+// legacyDecoder understands the original ad hoc ConfigFile/ServiceJSON
+// schema documented in this file's package comment.
+type legacyDecoder struct{}
+
+func (legacyDecoder) Decode(data []byte) ([]api.Service, []api.Endpoints, error) {
+	// ConfigFile has no "Endpoints" field of its own (each service's
+	// endpoints live nested under its own entry in "Services" instead), but
+	// encoding/json silently ignores unknown fields, so a ManifestConfig
+	// payload - which does have a top-level "Endpoints" array - would
+	// otherwise "successfully" decode here with every service losing its
+	// real data. Reject it explicitly so multiDecoder falls through to
+	// manifestDecoder instead.
+	var probe struct {
+		Endpoints json.RawMessage `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(data, &probe); err == nil && len(probe.Endpoints) > 0 {
+		return nil, nil, fmt.Errorf("data has a top-level Endpoints field; this is a native manifest, not the legacy ConfigFile schema")
+	}
+
+	config := new(ConfigFile)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, nil, err
+	}
+	services := make([]api.Service, len(config.Services))
+	endpoints := make([]api.Endpoints, len(config.Services))
+	for i, service := range config.Services {
+		services[i] = api.Service{JSONBase: api.JSONBase{ID: service.Name}, Port: service.Port}
+		endpoints[i] = api.Endpoints{Name: service.Name, Endpoints: service.Endpoints}
+	}
+	return services, endpoints, nil
+}
+
+// manifestDecoder understands ManifestConfig, i.e. native api.Service and
+// api.Endpoints objects such as kubectl would produce.
+type manifestDecoder struct{}
+
+func (manifestDecoder) Decode(data []byte) ([]api.Service, []api.Endpoints, error) {
+	config := new(ManifestConfig)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, nil, err
+	}
+	return config.Services, config.Endpoints, nil
+}
+
+// multiDecoder tries each Decoder in order and returns the first successful
+// result, or the last error if none of them could decode the data.
+type multiDecoder struct {
+	decoders []Decoder
+}
+
+func (m multiDecoder) Decode(data []byte) ([]api.Service, []api.Endpoints, error) {
+	var lastErr error
+	for _, d := range m.decoders {
+		services, endpoints, err := d.Decode(data)
+		if err == nil {
+			return services, endpoints, nil
+		}
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+// defaultDecoder accepts either the legacy schema or a native manifest.
+var defaultDecoder Decoder = multiDecoder{decoders: []Decoder{legacyDecoder{}, manifestDecoder{}}}
+
 type ConfigSourceFile struct {
 	serviceChannel   chan ServiceUpdate
 	endpointsChannel chan EndpointsUpdate
 	filename         string
+	decoder          Decoder
 }
 
 func NewConfigSourceFile(filename string, serviceChannel chan ServiceUpdate, endpointsChannel chan EndpointsUpdate) ConfigSourceFile {
+	return NewConfigSourceFileWithDecoder(filename, serviceChannel, endpointsChannel, defaultDecoder)
+}
+
+// NewConfigSourceFileWithDecoder is like NewConfigSourceFile but lets the
+// caller supply a Decoder, for sources whose schema is neither the legacy
+// one nor a native manifest.
+func NewConfigSourceFileWithDecoder(filename string, serviceChannel chan ServiceUpdate, endpointsChannel chan EndpointsUpdate, decoder Decoder) ConfigSourceFile {
 	config := ConfigSourceFile{
 		filename:         filename,
 		serviceChannel:   serviceChannel,
 		endpointsChannel: endpointsChannel,
+		decoder:          decoder,
 	}
 	go config.Run()
 	return config
 }
 
+// waitForWatch retries watcher.Add(s.filename) with capped exponential
+// backoff until it succeeds. s.filename routinely does not exist yet the
+// moment a source is constructed - the manifest that will populate it is
+// still being written by another process - so a single failed Add must not
+// be treated as fatal the way it is for other watcher errors.
+func (s ConfigSourceFile) waitForWatch(watcher *fsnotify.Watcher) {
+	wait := watchRetryInterval
+	for {
+		err := watcher.Add(s.filename)
+		if err == nil {
+			return
+		}
+		glog.Errorf("Unable to watch %s, retrying in %v: %v", s.filename, wait, err)
+		time.Sleep(wait)
+		if wait *= 2; wait > watchRetryMax {
+			wait = watchRetryMax
+		}
+	}
+}
+
 func (s ConfigSourceFile) Run() {
-	glog.Infof("Watching file %s", s.filename)
-	var lastData []byte
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Errorf("Unable to create a file watcher for %s, configuration will not be observed: %v", s.filename, err)
+		return
+	}
+	defer watcher.Close()
+
+	s.waitForWatch(watcher)
+	glog.Infof("Watching %s", s.filename)
+
 	var lastServices []api.Service
 	var lastEndpoints []api.Endpoints
-
-	for {
-		data, err := ioutil.ReadFile(s.filename)
+	reload := func() {
+		services, endpoints, err := s.load()
 		if err != nil {
-			glog.Errorf("Couldn't read file: %s : %v", s.filename, err)
-			continue
+			glog.Errorf("Couldn't load configuration from %s: %v", s.filename, err)
+			return
+		}
+		if !reflect.DeepEqual(lastServices, services) {
+			s.serviceChannel <- ServiceUpdate{Op: SET, Services: services}
+			lastServices = services
 		}
+		if !reflect.DeepEqual(lastEndpoints, endpoints) {
+			s.endpointsChannel <- EndpointsUpdate{Op: SET, Endpoints: endpoints}
+			lastEndpoints = endpoints
+		}
+	}
 
-		if bytes.Equal(lastData, data) {
-			continue
+	// Pick up whatever is already there before the first change event.
+	reload()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				// Many config managers write a new version to a temp path
+				// and rename it over s.filename, which removes the inode
+				// our watch was on; inotify does not follow the name, so
+				// without re-subscribing here we'd never see another event
+				// for this path again.
+				s.waitForWatch(watcher)
+			}
+			debounce = time.After(debounceInterval)
+		case <-debounce:
+			debounce = nil
+			reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			glog.Errorf("Watch error on %s: %v", s.filename, err)
 		}
-		lastData = data
+	}
+}
+
+// load reads s.filename, decoding a single file or, if it names a
+// directory, every regular file directly inside it, unioned together so a
+// directory of plain manifests behaves like one big config file.
+func (s ConfigSourceFile) load() ([]api.Service, []api.Endpoints, error) {
+	info, err := os.Stat(s.filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return s.loadFile(s.filename)
+	}
 
-		config := new(ConfigFile)
-		if err = json.Unmarshal(data, config); err != nil {
-			glog.Errorf("Couldn't unmarshal configuration from file : %s %v", data, err)
+	entries, err := ioutil.ReadDir(s.filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var services []api.Service
+	var endpoints []api.Endpoints
+	for _, entry := range entries {
+		if entry.IsDir() {
 			continue
 		}
-		// Ok, we have a valid configuration, send to channel for
-		// rejiggering.
-		newServices := make([]api.Service, len(config.Services))
-		newEndpoints := make([]api.Endpoints, len(config.Services))
-		for i, service := range config.Services {
-			newServices[i] = api.Service{JSONBase: api.JSONBase{ID: service.Name}, Port: service.Port}
-			newEndpoints[i] = api.Endpoints{Name: service.Name, Endpoints: service.Endpoints}
-		}
-		if !reflect.DeepEqual(lastServices, newServices) {
-			serviceUpdate := ServiceUpdate{Op: SET, Services: newServices}
-			s.serviceChannel <- serviceUpdate
-			lastServices = newServices
-		}
-		if !reflect.DeepEqual(lastEndpoints, newEndpoints) {
-			endpointsUpdate := EndpointsUpdate{Op: SET, Endpoints: newEndpoints}
-			s.endpointsChannel <- endpointsUpdate
-			lastEndpoints = newEndpoints
+		path := filepath.Join(s.filename, entry.Name())
+		fileServices, fileEndpoints, err := s.loadFile(path)
+		if err != nil {
+			glog.Errorf("Skipping %s: %v", path, err)
+			continue
 		}
+		services = append(services, fileServices...)
+		endpoints = append(endpoints, fileEndpoints...)
+	}
+	return services, endpoints, nil
+}
 
-		time.Sleep(5 * time.Second)
+func (s ConfigSourceFile) loadFile(path string) ([]api.Service, []api.Endpoints, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
 	}
+	return s.decoder.Decode(data)
 }
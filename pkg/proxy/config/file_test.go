@@ -0,0 +1,201 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestLegacyDecoder(t *testing.T) {
+	data := []byte(`{"Services": [{"Name":"nodejs","Port":10000,"Endpoints":["10.0.0.1:8000"]}]}`)
+	services, endpoints, err := legacyDecoder{}.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if len(services) != 1 || services[0].ID != "nodejs" || services[0].Port != 10000 {
+		t.Fatalf("unexpected services: %+v", services)
+	}
+	if len(endpoints) != 1 || endpoints[0].Name != "nodejs" || len(endpoints[0].Endpoints) != 1 {
+		t.Fatalf("unexpected endpoints: %+v", endpoints)
+	}
+}
+
+func TestManifestDecoder(t *testing.T) {
+	data := []byte(`{}`)
+	services, endpoints, err := manifestDecoder{}.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if len(services) != 0 || len(endpoints) != 0 {
+		t.Fatalf("expected an empty manifest to decode to nothing, got services=%+v endpoints=%+v", services, endpoints)
+	}
+}
+
+func TestMultiDecoderFallsBackToNextDecoder(t *testing.T) {
+	data := []byte(`not valid json at all`)
+	if _, _, err := defaultDecoder.Decode(data); err == nil {
+		t.Fatal("expected an error when no decoder understands the data")
+	}
+
+	legacy := []byte(`{"Services": [{"Name":"nodejs","Port":10000,"Endpoints":["10.0.0.1:8000"]}]}`)
+	services, _, err := defaultDecoder.Decode(legacy)
+	if err != nil {
+		t.Fatalf("defaultDecoder failed to decode a legacy-schema file: %v", err)
+	}
+	if len(services) != 1 || services[0].ID != "nodejs" {
+		t.Fatalf("unexpected services from legacy-schema decode: %+v", services)
+	}
+}
+
+// TestMultiDecoderRoutesManifestToManifestDecoder guards against
+// legacyDecoder silently "succeeding" on a native manifest payload: since
+// encoding/json ignores fields ConfigFile doesn't declare, a ManifestConfig
+// document would otherwise decode-without-erroring as an empty/blank legacy
+// config and never reach manifestDecoder.
+func TestMultiDecoderRoutesManifestToManifestDecoder(t *testing.T) {
+	manifest := []byte(`{"Services":[{"Port":10001}],"Endpoints":[{"Name":"mysql","Endpoints":["10.0.0.2:9000"]}]}`)
+	_, endpoints, err := defaultDecoder.Decode(manifest)
+	if err != nil {
+		t.Fatalf("defaultDecoder failed to decode a native manifest: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].Name != "mysql" || len(endpoints[0].Endpoints) != 1 {
+		t.Fatalf("expected the manifest's top-level Endpoints to survive decoding, got %+v (legacyDecoder likely swallowed it silently)", endpoints)
+	}
+}
+
+func TestLoadUnionsFilesInDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-source-file-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	nodejs := `{"Services": [{"Name":"nodejs","Port":10000,"Endpoints":["10.0.0.1:8000"]}]}`
+	mysql := `{"Services": [{"Name":"mysql","Port":10001,"Endpoints":["10.0.0.2:9000"]}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "nodejs.json"), []byte(nodejs), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "mysql.json"), []byte(mysql), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	s := ConfigSourceFile{filename: dir, decoder: defaultDecoder}
+	services, _, err := s.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("expected 2 services unioned from the directory, got %d: %+v", len(services), services)
+	}
+}
+
+func TestWaitForWatchRetriesUntilPathExists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-source-file-watch-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.json")
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	defer watcher.Close()
+
+	s := ConfigSourceFile{filename: path}
+	done := make(chan struct{})
+	go func() {
+		s.waitForWatch(watcher)
+		close(done)
+	}()
+
+	// The file does not exist yet, so waitForWatch must still be retrying.
+	select {
+	case <-done:
+		t.Fatal("waitForWatch returned before the watched path existed")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForWatch never succeeded after the watched path was created")
+	}
+}
+
+// TestRunResubscribesAfterAtomicRename exercises the write-to-temp-then-
+// rename-over-original pattern many config managers use: it must not leave
+// the watch silently dead, since a rename drops the inode the original
+// watcher.Add subscribed to.
+func TestRunResubscribesAfterAtomicRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-source-file-rename-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.json")
+
+	nodejs := `{"Services": [{"Name":"nodejs","Port":10000,"Endpoints":["10.0.0.1:8000"]}]}`
+	if err := ioutil.WriteFile(path, []byte(nodejs), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	serviceChannel := make(chan ServiceUpdate, 10)
+	endpointsChannel := make(chan EndpointsUpdate, 10)
+	s := ConfigSourceFile{filename: path, decoder: defaultDecoder, serviceChannel: serviceChannel, endpointsChannel: endpointsChannel}
+	go s.Run()
+
+	waitForServiceNamed := func(name string) {
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case update := <-serviceChannel:
+				if len(update.Services) == 1 && update.Services[0].ID == name {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("timed out waiting for a ServiceUpdate naming %s", name)
+			}
+		}
+	}
+
+	waitForServiceNamed("nodejs")
+
+	// Simulate an atomic config update: write the new content to a temp
+	// path in the same directory, then rename it over the watched file.
+	mysql := `{"Services": [{"Name":"mysql","Port":10001,"Endpoints":["10.0.0.2:9000"]}]}`
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(mysql), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	waitForServiceNamed("mysql")
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package strategicpatch computes a two-way "strategic merge" patch between
+// two JSON documents of a known Go type. Unlike a plain JSON merge patch
+// (RFC 7396), which replaces list fields wholesale, a strategic merge patch
+// consults the type's patchStrategy/patchMergeKey struct tags so list
+// fields that opt in (patchStrategy:"merge") are merged element-by-element,
+// keyed by patchMergeKey, instead of replaced as a unit. Fields with no such
+// tag fall back to whole-value replacement, identical to a JSON merge
+// patch.
+package strategicpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrNoStructMetadata is returned by CreateTwoWayMergePatch when dataStruct
+// carries no patchStrategy/patchMergeKey tags anywhere in its type graph,
+// meaning a strategic merge patch would behave no differently than a plain
+// JSON merge patch. Callers use this to fall back to a cheaper merge-patch
+// implementation instead of paying for one that has nothing extra to offer.
+var ErrNoStructMetadata = errors.New("strategicpatch: dataStruct has no patchStrategy/patchMergeKey metadata")
+
+// fieldInfo is the strategic-merge metadata for one field of a struct,
+// keyed by the field's JSON name.
+type fieldInfo struct {
+	mergeKey string
+	isMerge  bool
+	elemType reflect.Type // struct type to recurse into: the field's own
+	// struct type for a nested-object field, or a slice/array field's
+	// element struct type (merge-keyed or not) for a nested-list field.
+}
+
+// structType reflects t, which must be a struct or a pointer to one, into a
+// map from JSON field name to its strategic-merge metadata, and reports
+// whether t's type graph carries patchStrategy/patchMergeKey tags anywhere,
+// not just on t's own fields: real API types (Pod, Deployment, ...) always
+// put those tags several levels down (e.g. Spec.Template.Spec.Containers),
+// never on the root type itself.
+func structType(t reflect.Type) (map[string]fieldInfo, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	fields := make(map[string]fieldInfo)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Name
+		if jsonTag := f.Tag.Get("json"); len(jsonTag) > 0 {
+			if parts := strings.SplitN(jsonTag, ",", 2); len(parts[0]) > 0 {
+				name = parts[0]
+			}
+		}
+		info := fieldInfo{
+			mergeKey: f.Tag.Get("patchMergeKey"),
+			isMerge:  f.Tag.Get("patchStrategy") == "merge",
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+		}
+		if ft.Kind() == reflect.Struct {
+			info.elemType = ft
+		}
+		fields[name] = info
+	}
+	return fields, hasMergeMetadata(t, make(map[reflect.Type]bool))
+}
+
+// hasMergeMetadata reports whether t, or any struct type reachable from it
+// by descending into fields and slice/array/pointer element types, carries
+// a patchStrategy:"merge"/patchMergeKey tag pair. seen guards against
+// infinite recursion on self-referential types.
+func hasMergeMetadata(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("patchStrategy") == "merge" && len(f.Tag.Get("patchMergeKey")) > 0 {
+			return true
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && hasMergeMetadata(ft, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTwoWayMergePatch computes a strategic merge patch that turns
+// original into modified, using dataStruct's patchStrategy/patchMergeKey
+// tags to merge list fields by key instead of by index. It returns
+// ErrNoStructMetadata if dataStruct's type (recursively) carries no such
+// tags, since in that case the result would be indistinguishable from a
+// plain JSON merge patch and callers are expected to use one instead.
+func CreateTwoWayMergePatch(original, modified []byte, dataStruct interface{}) ([]byte, error) {
+	t := reflect.TypeOf(dataStruct)
+	if t == nil {
+		return nil, ErrNoStructMetadata
+	}
+	fields, hasMetadata := structType(t)
+	if !hasMetadata {
+		return nil, ErrNoStructMetadata
+	}
+
+	var originalMap, modifiedMap map[string]interface{}
+	if err := json.Unmarshal(original, &originalMap); err != nil {
+		return nil, fmt.Errorf("strategicpatch: decoding original: %v", err)
+	}
+	if err := json.Unmarshal(modified, &modifiedMap); err != nil {
+		return nil, fmt.Errorf("strategicpatch: decoding modified: %v", err)
+	}
+
+	patch := diffMaps(originalMap, modifiedMap, fields)
+	return json.Marshal(patch)
+}
+
+// diffMaps produces the patch map that turns original into modified: added
+// or changed keys take their new value, keys present only in original are
+// marked for deletion with nil, and keys equal in both are omitted. fields
+// supplies the strategic-merge metadata (if any) for this map's own struct
+// type; it decides whether a []interface{} value is merged by key
+// (diffLists) or replaced wholesale, and supplies the metadata passed down
+// when a nested object is diffed.
+func diffMaps(original, modified map[string]interface{}, fields map[string]fieldInfo) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for key, modifiedValue := range modified {
+		originalValue, existed := original[key]
+		if !existed {
+			patch[key] = modifiedValue
+			continue
+		}
+		if reflect.DeepEqual(originalValue, modifiedValue) {
+			continue
+		}
+		info := fields[key]
+		if originalNested, ok := originalValue.(map[string]interface{}); ok {
+			if modifiedNested, ok := modifiedValue.(map[string]interface{}); ok {
+				patch[key] = diffMaps(originalNested, modifiedNested, nestedFields(info))
+				continue
+			}
+		}
+		if info.isMerge && len(info.mergeKey) > 0 {
+			if originalList, ok := originalValue.([]interface{}); ok {
+				if modifiedList, ok := modifiedValue.([]interface{}); ok {
+					patch[key] = diffLists(originalList, modifiedList, info)
+					continue
+				}
+			}
+		}
+		patch[key] = modifiedValue
+	}
+	for key := range original {
+		if _, stillPresent := modified[key]; !stillPresent {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
+
+// nestedFields returns the strategic-merge metadata for the struct type
+// info points into its own struct (a nested-object field) or its slice
+// elements (a nested-list field) hold, so a nested diffMaps call still
+// knows which of its own fields merge by key.
+func nestedFields(info fieldInfo) map[string]fieldInfo {
+	if info.elemType == nil {
+		return nil
+	}
+	fields, _ := structType(info.elemType)
+	return fields
+}
+
+// deleteDirective marks a merge-keyed list element for server-side removal
+// in a strategic merge patch: the API server deletes the original element
+// matching mergeKey instead of unioning this entry in, per the
+// patchStrategy:"merge" convention.
+const deleteDirective = "$patch"
+
+// diffLists merges original into modified by mergeKey: elements present in
+// both (matched by mergeKey) are diffed recursively via diffMaps, elements
+// only in modified are appended as-is, and elements only in original are
+// marked with a $patch:"delete" directive instead of being dropped, since a
+// patchStrategy:"merge" list is unioned by the API server - an element
+// simply missing from the patch body is not removed from the live object.
+func diffLists(original, modified []interface{}, info fieldInfo) []interface{} {
+	elemFields, _ := structType(info.elemType)
+	originalByKey := make(map[interface{}]map[string]interface{}, len(original))
+	for _, item := range original {
+		if m, ok := item.(map[string]interface{}); ok {
+			originalByKey[m[info.mergeKey]] = m
+		}
+	}
+	seen := make(map[interface{}]bool, len(modified))
+	result := make([]interface{}, 0, len(modified))
+	for _, item := range modified {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			result = append(result, item)
+			continue
+		}
+		key := m[info.mergeKey]
+		seen[key] = true
+		if orig, existed := originalByKey[key]; existed {
+			merged := diffMaps(orig, m, elemFields)
+			merged[info.mergeKey] = key
+			result = append(result, merged)
+			continue
+		}
+		result = append(result, m)
+	}
+	for key, orig := range originalByKey {
+		if seen[key] {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			info.mergeKey:   orig[info.mergeKey],
+			deleteDirective: "delete",
+		})
+	}
+	return result
+}
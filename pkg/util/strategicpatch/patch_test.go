@@ -0,0 +1,155 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategicpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type plainPod struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+func TestCreateTwoWayMergePatchNoMetadataReturnsErrNoStructMetadata(t *testing.T) {
+	_, err := CreateTwoWayMergePatch([]byte(`{}`), []byte(`{"name":"a"}`), &plainPod{})
+	if err != ErrNoStructMetadata {
+		t.Fatalf("expected ErrNoStructMetadata for a struct with no patchStrategy tags, got %v", err)
+	}
+}
+
+type container struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+type podWithMergeList struct {
+	Name       string      `json:"name"`
+	Containers []container `json:"containers" patchStrategy:"merge" patchMergeKey:"name"`
+}
+
+func decodePatch(t *testing.T, patch []byte) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(patch, &m); err != nil {
+		t.Fatalf("decoding patch: %v", err)
+	}
+	return m
+}
+
+func TestCreateTwoWayMergePatchScalarChange(t *testing.T) {
+	original := []byte(`{"name":"web","containers":[{"name":"app","image":"v1"}]}`)
+	modified := []byte(`{"name":"web2","containers":[{"name":"app","image":"v1"}]}`)
+	patch, err := CreateTwoWayMergePatch(original, modified, &podWithMergeList{})
+	if err != nil {
+		t.Fatalf("CreateTwoWayMergePatch: %v", err)
+	}
+	got := decodePatch(t, patch)
+	want := map[string]interface{}{"name": "web2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("patch = %v, want %v", got, want)
+	}
+}
+
+func TestCreateTwoWayMergePatchMergesListByKey(t *testing.T) {
+	original := []byte(`{"name":"web","containers":[{"name":"app","image":"v1"},{"name":"sidecar","image":"v1"}]}`)
+	modified := []byte(`{"name":"web","containers":[{"name":"app","image":"v2"},{"name":"new","image":"v1"}]}`)
+	patch, err := CreateTwoWayMergePatch(original, modified, &podWithMergeList{})
+	if err != nil {
+		t.Fatalf("CreateTwoWayMergePatch: %v", err)
+	}
+	got := decodePatch(t, patch)
+	containers, ok := got["containers"].([]interface{})
+	if !ok {
+		t.Fatalf("patch has no containers list: %v", got)
+	}
+	if len(containers) != 3 {
+		t.Fatalf("expected 3 entries (app, new, and a delete directive for sidecar) in the merged containers list, got %d: %v", len(containers), containers)
+	}
+	byName := make(map[string]map[string]interface{}, len(containers))
+	for _, c := range containers {
+		m := c.(map[string]interface{})
+		byName[m["name"].(string)] = m
+	}
+	app, ok := byName["app"]
+	if !ok {
+		t.Fatalf("merged entry for app is missing: %v", containers)
+	}
+	if app["image"] != "v2" {
+		t.Fatalf("expected app's image to be diffed to v2, got %v", app)
+	}
+	newEntry, ok := byName["new"]
+	if !ok || newEntry["image"] != "v1" {
+		t.Fatalf("expected the new container to be appended as-is, got %v", byName["new"])
+	}
+	sidecar, ok := byName["sidecar"]
+	if !ok {
+		t.Fatalf("expected a $patch:\"delete\" directive for the removed sidecar container, got %v", containers)
+	}
+	if sidecar["$patch"] != "delete" {
+		t.Fatalf("expected sidecar's entry to carry $patch:\"delete\" so the API server actually removes it, got %v", sidecar)
+	}
+}
+
+// podSpec/pod mirror the real Kubernetes layout where patchStrategy tags
+// live on a deeply nested field (Pod.Spec.Containers), never on the root
+// type's own fields.
+type podSpec struct {
+	Containers []container `json:"containers" patchStrategy:"merge" patchMergeKey:"name"`
+}
+
+type pod struct {
+	Name string  `json:"name"`
+	Spec podSpec `json:"spec"`
+}
+
+func TestCreateTwoWayMergePatchFindsNestedMetadata(t *testing.T) {
+	original := []byte(`{"name":"web","spec":{"containers":[{"name":"app","image":"v1"},{"name":"sidecar","image":"v1"}]}}`)
+	modified := []byte(`{"name":"web","spec":{"containers":[{"name":"app","image":"v2"},{"name":"new","image":"v1"}]}}`)
+	patch, err := CreateTwoWayMergePatch(original, modified, &pod{})
+	if err != nil {
+		t.Fatalf("CreateTwoWayMergePatch: %v", err)
+	}
+	got := decodePatch(t, patch)
+	spec, ok := got["spec"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("patch has no spec object: %v", got)
+	}
+	containers, ok := spec["containers"].([]interface{})
+	if !ok {
+		t.Fatalf("patch has no spec.containers list: %v", spec)
+	}
+	if len(containers) != 3 {
+		t.Fatalf("expected the nested merge-keyed list to be merged by key (app, new, and a delete directive for sidecar), not replaced wholesale, got %d entries: %v", len(containers), containers)
+	}
+	byName := make(map[string]map[string]interface{}, len(containers))
+	for _, c := range containers {
+		m := c.(map[string]interface{})
+		byName[m["name"].(string)] = m
+	}
+	if byName["app"]["image"] != "v2" {
+		t.Fatalf("expected app's image to be diffed to v2, got %v", byName["app"])
+	}
+	if byName["new"]["image"] != "v1" {
+		t.Fatalf("expected the new container to be appended as-is, got %v", byName["new"])
+	}
+	if byName["sidecar"]["$patch"] != "delete" {
+		t.Fatalf("expected a $patch:\"delete\" directive for the removed sidecar container, got %v", byName["sidecar"])
+	}
+}